@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// windowedMetric exposes a single Mailgun domain-stats metric two ways: a
+// gauge reporting the raw value Mailgun returned for the configured
+// --stats.resolution/--stats.window (labelled with that window, since it is
+// not monotonic and can shrink as the window slides), and a CounterVec that
+// accumulates the deltas seen between successive scrapes, so it behaves like
+// a real Prometheus counter. The last-seen values are held in memory only:
+// the counter starts at zero when the process starts, and the first scrape
+// after that adds the whole window value.
+type windowedMetric struct {
+	windowDesc *prometheus.Desc
+	total      *prometheus.CounterVec
+
+	mu   sync.Mutex
+	last map[string]float64
+}
+
+// newWindowedMetric builds a windowedMetric named mailgun_domain_stats_<metric>_window
+// (gauge) and mailgun_domain_stats_<metric>_total (counter). extraLabels are
+// applied to both metrics in addition to the "name" domain label; the gauge
+// additionally carries a "window" label.
+func newWindowedMetric(metric, help string, extraLabels ...string) *windowedMetric {
+	labels := append([]string{"name"}, extraLabels...)
+	windowLabels := append(append([]string{}, labels...), "window")
+
+	return &windowedMetric{
+		windowDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "domain_stats", fmt.Sprintf("%s_window", metric)),
+			help,
+			windowLabels,
+			nil,
+		),
+		total: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "domain_stats",
+			Name:      fmt.Sprintf("%s_total", metric),
+			Help:      help,
+		}, labels),
+		last: make(map[string]float64),
+	}
+}
+
+// Describe reports both the gauge descriptor and the counter's descriptors.
+func (m *windowedMetric) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.windowDesc
+	m.total.Describe(ch)
+}
+
+// Collect forwards the accumulated counter values. The gauge is emitted
+// directly by observe, since it needs the window label value.
+func (m *windowedMetric) Collect(ch chan<- prometheus.Metric) {
+	m.total.Collect(ch)
+}
+
+// observe records one scrape's value for the given label values (matching
+// the extraLabels passed to newWindowedMetric, domain first): it emits the
+// window gauge immediately and adds the non-negative delta since the last
+// scrape to the running counter. A negative delta - the window rolling
+// forward and dropping more than it gained - is treated as no change rather
+// than a decrease, since Prometheus counters must never go down.
+func (m *windowedMetric) observe(ch chan<- prometheus.Metric, window string, value float64, labelValues ...string) {
+	ch <- prometheus.MustNewConstMetric(
+		m.windowDesc, prometheus.GaugeValue, value, append(append([]string{}, labelValues...), window)...,
+	)
+
+	key := strings.Join(labelValues, "\xff")
+
+	m.mu.Lock()
+	delta := value - m.last[key]
+	m.last[key] = value
+	m.mu.Unlock()
+
+	if delta > 0 {
+		m.total.WithLabelValues(labelValues...).Add(delta)
+	}
+}
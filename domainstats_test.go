@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParseResolution(t *testing.T) {
+	for _, valid := range []string{"hour", "day", "month"} {
+		if _, err := parseResolution(valid); err != nil {
+			t.Errorf("expected %q to be a valid resolution, got error %v", valid, err)
+		}
+	}
+
+	if _, err := parseResolution("fortnight"); err == nil {
+		t.Error("expected an invalid resolution to return an error")
+	}
+}
+
+func TestWindowLabel(t *testing.T) {
+	cases := map[time.Duration]string{
+		24 * time.Hour:     "1d",
+		7 * 24 * time.Hour: "7d",
+		90 * time.Minute:   (90 * time.Minute).String(),
+	}
+
+	for window, want := range cases {
+		if got := windowLabel(window); got != want {
+			t.Errorf("windowLabel(%v) = %q, want %q", window, got, want)
+		}
+	}
+}
+
+func counterValue(t *testing.T, m *windowedMetric, labelValues ...string) float64 {
+	t.Helper()
+
+	metric, err := m.total.GetMetricWithLabelValues(labelValues...)
+	if err != nil {
+		t.Fatalf("GetMetricWithLabelValues: %v", err)
+	}
+
+	var out dto.Metric
+	if err := metric.Write(&out); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	return out.GetCounter().GetValue()
+}
+
+func TestWindowedMetricAccumulatesPositiveDeltas(t *testing.T) {
+	m := newWindowedMetric("accepted", "help")
+	ch := make(chan prometheus.Metric, 8)
+
+	m.observe(ch, "7d", 10, "example.com")
+	m.observe(ch, "7d", 15, "example.com")
+
+	if got := counterValue(t, m, "example.com"); got != 15 {
+		t.Errorf("expected the counter to accumulate to the latest total 15, got %v", got)
+	}
+}
+
+func TestWindowedMetricIgnoresNegativeDeltas(t *testing.T) {
+	m := newWindowedMetric("accepted", "help")
+	ch := make(chan prometheus.Metric, 8)
+
+	m.observe(ch, "7d", 10, "example.com")
+	m.observe(ch, "7d", 4, "example.com")
+
+	if got := counterValue(t, m, "example.com"); got != 10 {
+		t.Errorf("expected a window rolling backwards to leave the counter unchanged at 10, got %v", got)
+	}
+}
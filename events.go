@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mailgun/mailgun-go/v4"
+	"github.com/mailgun/mailgun-go/v4/events"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const eventsRequestTimeout = 60 * time.Second
+
+// EventsCollector derives per-message metrics from the Mailgun Events API,
+// which carries information (bounce reasons, delivery latency, tags) that
+// the aggregate domain stats used by Exporter cannot express.
+//
+// It keeps two pieces of state across scrapes: the last event timestamp
+// seen per domain, so a restart with --state-file set doesn't re-walk the
+// whole --events.max-age window (without a state file it is kept in memory
+// only), and the accepted-at timestamp per message-id, so a later
+// delivered/failed event for the same message can be turned into a
+// delivery-duration observation even if accepted and the terminal event
+// land in different scrapes.
+type EventsCollector struct {
+	domains   []string
+	apiKey    string
+	apiBase   string
+	maxAge    time.Duration
+	stateFile string
+
+	labelTag             bool
+	labelRecipientDomain bool
+
+	mu         sync.Mutex
+	lastSeen   map[string]time.Time
+	acceptedAt map[string]time.Time
+
+	deliveryDuration *prometheus.HistogramVec
+	bounceTotal      *prometheus.CounterVec
+}
+
+// NewEventsCollector returns an initialized EventsCollector. If stateFile is
+// non-empty and exists, the per-domain last-seen timestamps are loaded from
+// it so a restart doesn't double-count events already processed.
+func NewEventsCollector(
+	domains []string,
+	apiKey, apiBase string,
+	maxAge time.Duration,
+	stateFile string,
+	labelTag, labelRecipientDomain bool,
+) *EventsCollector {
+	labels := []string{"domain"}
+	if labelTag {
+		labels = append(labels, "tag")
+	}
+	if labelRecipientDomain {
+		labels = append(labels, "recipient_domain")
+	}
+
+	bounceLabels := append(append([]string{}, labels...), "severity", "reason", "code")
+
+	ec := &EventsCollector{
+		domains:              domains,
+		apiKey:               apiKey,
+		apiBase:              apiBase,
+		maxAge:               maxAge,
+		stateFile:            stateFile,
+		labelTag:             labelTag,
+		labelRecipientDomain: labelRecipientDomain,
+		lastSeen:             make(map[string]time.Time),
+		acceptedAt:           make(map[string]time.Time),
+		deliveryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "delivery_duration_seconds",
+			Help:      "Time between Mailgun accepting a message and it being delivered or permanently failing.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 11),
+		}, labels),
+		bounceTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "bounce_total",
+			Help:      "Count of failed events from the Mailgun Events API, by failure severity/reason/code.",
+		}, bounceLabels),
+	}
+
+	ec.loadState()
+
+	return ec
+}
+
+// Describe implements prometheus.Collector.
+func (ec *EventsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ec.deliveryDuration.Describe(ch)
+	ec.bounceTotal.Describe(ch)
+}
+
+// Collect implements prometheus.Collector. It pages through the Events API
+// for every domain since the last successful scrape, folds what it finds
+// into the internal histogram/counter vectors, and forwards those.
+func (ec *EventsCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now().UTC()
+	ec.evictExpiredAccepted(now)
+
+	for _, domain := range ec.domains {
+		start := time.Now()
+		err := ec.collectDomain(domain, now)
+		observeScrape(domain, "events", start, err)
+
+		if err != nil {
+			log.Error().Err(err).Str("domain", domain).Msg("failed to collect Mailgun events")
+		}
+	}
+
+	ec.saveState()
+
+	ec.deliveryDuration.Collect(ch)
+	ec.bounceTotal.Collect(ch)
+}
+
+func (ec *EventsCollector) collectDomain(domain string, now time.Time) error {
+	begin := ec.domainBegin(domain, now)
+
+	mg := mailgun.NewMailgun(domain, ec.apiKey)
+	if ec.apiBase != "" {
+		mg.SetAPIBase(ec.apiBase)
+	}
+	mg.SetClient(instrumentedMailgunClient("events"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), eventsRequestTimeout)
+	defer cancel()
+
+	it := mg.ListEventsWithDomain(&mailgun.ListEventOptions{
+		Begin:          begin,
+		End:            now,
+		ForceAscending: true,
+		Limit:          300,
+	}, domain)
+
+	latest := begin
+
+	var page []mailgun.Event
+	for it.Next(ctx, &page) {
+		for _, event := range page {
+			ec.observeEvent(domain, event)
+			if ts := event.GetTimestamp(); ts.After(latest) {
+				latest = ts
+			}
+		}
+	}
+
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	ec.mu.Lock()
+	ec.lastSeen[domain] = latest
+	ec.mu.Unlock()
+
+	return nil
+}
+
+// domainBegin returns the timestamp to resume paging from for domain,
+// capped at --events.max-age ago so a long-dead state file can't trigger an
+// unbounded backfill.
+func (ec *EventsCollector) domainBegin(domain string, now time.Time) time.Time {
+	oldest := now.Add(-ec.maxAge)
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	begin, ok := ec.lastSeen[domain]
+	if !ok || begin.Before(oldest) {
+		return oldest
+	}
+
+	return begin
+}
+
+func (ec *EventsCollector) observeEvent(domain string, event mailgun.Event) {
+	switch e := event.(type) {
+	case *events.Accepted:
+		ec.mu.Lock()
+		ec.acceptedAt[e.Message.Headers.MessageID] = e.GetTimestamp()
+		ec.mu.Unlock()
+	case *events.Delivered:
+		ec.observeDuration(domain, e.Message.Headers.MessageID, e.GetTimestamp(), ec.labelValues(domain, e.Tags, e.RecipientDomain))
+	case *events.Failed:
+		labels := ec.labelValues(domain, e.Tags, e.RecipientDomain)
+		if e.Severity == "permanent" {
+			ec.observeDuration(domain, e.Message.Headers.MessageID, e.GetTimestamp(), labels)
+		}
+		ec.bounceTotal.WithLabelValues(
+			append(labels, e.Severity, e.Reason, strconv.Itoa(e.DeliveryStatus.Code))...,
+		).Inc()
+	}
+}
+
+func (ec *EventsCollector) observeDuration(domain, messageID string, at time.Time, labels []string) {
+	if messageID == "" {
+		return
+	}
+
+	ec.mu.Lock()
+	acceptedAt, ok := ec.acceptedAt[messageID]
+	if ok {
+		delete(ec.acceptedAt, messageID)
+	}
+	ec.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	ec.deliveryDuration.WithLabelValues(labels...).Observe(at.Sub(acceptedAt).Seconds())
+}
+
+// labelValues builds the label value tuple for a domain/event, honouring
+// which optional dimensions were allow-listed at construction time. The
+// returned slice has spare capacity so bounceTotal can append its own
+// severity/reason/code values without reallocating.
+func (ec *EventsCollector) labelValues(domain string, tags []string, recipientDomain string) []string {
+	values := make([]string, 1, 6)
+	values[0] = domain
+
+	if ec.labelTag {
+		tag := ""
+		if len(tags) > 0 {
+			tag = tags[0]
+		}
+		values = append(values, tag)
+	}
+
+	if ec.labelRecipientDomain {
+		values = append(values, recipientDomain)
+	}
+
+	return values
+}
+
+// evictExpiredAccepted drops accepted-event bookkeeping older than maxAge so
+// a message that never reaches a terminal state doesn't leak memory forever.
+func (ec *EventsCollector) evictExpiredAccepted(now time.Time) {
+	oldest := now.Add(-ec.maxAge)
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	for id, at := range ec.acceptedAt {
+		if at.Before(oldest) {
+			delete(ec.acceptedAt, id)
+		}
+	}
+}
+
+func (ec *EventsCollector) loadState() {
+	if ec.stateFile == "" {
+		return
+	}
+
+	data, err := os.ReadFile(ec.stateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error().Err(err).Str("file", ec.stateFile).Msg("failed to read events state file")
+		}
+
+		return
+	}
+
+	var lastSeen map[string]time.Time
+	if err := json.Unmarshal(data, &lastSeen); err != nil {
+		log.Error().Err(err).Str("file", ec.stateFile).Msg("failed to parse events state file")
+		return
+	}
+
+	ec.mu.Lock()
+	ec.lastSeen = lastSeen
+	ec.mu.Unlock()
+}
+
+func (ec *EventsCollector) saveState() {
+	if ec.stateFile == "" {
+		return
+	}
+
+	ec.mu.Lock()
+	data, err := json.Marshal(ec.lastSeen)
+	ec.mu.Unlock()
+
+	if err != nil {
+		log.Error().Err(err).Msg("failed to marshal events state")
+		return
+	}
+
+	if err := os.WriteFile(ec.stateFile, data, 0o644); err != nil {
+		log.Error().Err(err).Str("file", ec.stateFile).Msg("failed to write events state file")
+	}
+}
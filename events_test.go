@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLabelValuesHonoursAllowList(t *testing.T) {
+	ec := NewEventsCollector(nil, "key", "", time.Hour, "", false, false)
+	if got := ec.labelValues("example.com", []string{"welcome"}, "gmail.com"); len(got) != 1 || got[0] != "example.com" {
+		t.Errorf("expected only the domain label, got %v", got)
+	}
+
+	ec = NewEventsCollector(nil, "key", "", time.Hour, "", true, true)
+	got := ec.labelValues("example.com", []string{"welcome"}, "gmail.com")
+	want := []string{"example.com", "welcome", "gmail.com"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestLabelValuesDefaultsMissingTagToEmpty(t *testing.T) {
+	ec := NewEventsCollector(nil, "key", "", time.Hour, "", true, false)
+	got := ec.labelValues("example.com", nil, "gmail.com")
+	if len(got) != 2 || got[1] != "" {
+		t.Errorf("expected an empty tag label for an untagged message, got %v", got)
+	}
+}
+
+func TestDomainBeginCapsBackfillAtMaxAge(t *testing.T) {
+	ec := NewEventsCollector(nil, "key", "", time.Hour, "", false, false)
+	now := time.Date(2026, 7, 25, 12, 0, 0, 0, time.UTC)
+
+	if got := ec.domainBegin("example.com", now); !got.Equal(now.Add(-time.Hour)) {
+		t.Errorf("expected an unseen domain to begin at now-maxAge, got %v", got)
+	}
+
+	ec.lastSeen["example.com"] = now.Add(-48 * time.Hour)
+	if got := ec.domainBegin("example.com", now); !got.Equal(now.Add(-time.Hour)) {
+		t.Errorf("expected a stale last-seen timestamp to be capped at now-maxAge, got %v", got)
+	}
+
+	recent := now.Add(-time.Minute)
+	ec.lastSeen["example.com"] = recent
+	if got := ec.domainBegin("example.com", now); !got.Equal(recent) {
+		t.Errorf("expected a recent last-seen timestamp to be resumed from, got %v", got)
+	}
+}
+
+func TestEvictExpiredAcceptedDropsStaleEntries(t *testing.T) {
+	ec := NewEventsCollector(nil, "key", "", time.Hour, "", false, false)
+	now := time.Date(2026, 7, 25, 12, 0, 0, 0, time.UTC)
+
+	ec.acceptedAt["stale"] = now.Add(-2 * time.Hour)
+	ec.acceptedAt["fresh"] = now.Add(-time.Minute)
+
+	ec.evictExpiredAccepted(now)
+
+	if _, ok := ec.acceptedAt["stale"]; ok {
+		t.Error("expected the stale accepted-at entry to be evicted")
+	}
+	if _, ok := ec.acceptedAt["fresh"]; !ok {
+		t.Error("expected the fresh accepted-at entry to be kept")
+	}
+}
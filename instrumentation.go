@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Self-observability metrics for the exporter itself, as distinct from the
+// metrics it derives from Mailgun's APIs. scrapeDuration/scrapeErrors let an
+// operator alert on the exporter failing to talk to Mailgun; apiRequest*
+// let them tell a slow/erroring Mailgun API apart from a slow/erroring
+// exporter.
+var (
+	scrapeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "exporter",
+		Name:      "scrape_duration_seconds",
+		Help:      "Time spent collecting metrics for a single Mailgun domain.",
+	}, []string{"domain"})
+
+	scrapeErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "exporter",
+		Name:      "scrape_errors_total",
+		Help:      "Count of failed scrapes, by domain and collection stage (stats, events).",
+	}, []string{"domain", "stage"})
+
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Latency of requests made to the Mailgun API, by endpoint and response code.",
+	}, []string{"endpoint", "code"})
+
+	apiRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_requests_total",
+		Help:      "Count of requests made to the Mailgun API, by endpoint and response code.",
+	}, []string{"endpoint", "code"})
+
+	scrapeInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "exporter",
+		Name:      "scrape_in_flight",
+		Help:      "Number of per-domain Mailgun scrapes currently running. Pinned at --scrape.concurrency indicates it's the bottleneck.",
+	})
+)
+
+// registerInstrumentation registers the exporter's self-observability
+// metrics. The standard Go/process collectors are not registered here.
+func registerInstrumentation(reg prometheus.Registerer) {
+	reg.MustRegister(
+		scrapeDuration,
+		scrapeErrorsTotal,
+		apiRequestDuration,
+		apiRequestsTotal,
+		scrapeInFlight,
+	)
+}
+
+// instrumentedMailgunClient returns an http.Client whose RoundTripper
+// records apiRequestDuration/apiRequestsTotal for every request, curried
+// with endpoint so the two Mailgun API surfaces we call (domain stats,
+// events) are distinguishable without exceeding the "code"/"method" labels
+// promhttp.InstrumentRoundTripper* otherwise requires.
+func instrumentedMailgunClient(endpoint string) *http.Client {
+	labels := prometheus.Labels{"endpoint": endpoint}
+
+	var transport http.RoundTripper = http.DefaultTransport
+	transport = promhttp.InstrumentRoundTripperDuration(apiRequestDuration.MustCurryWith(labels), transport)
+	transport = promhttp.InstrumentRoundTripperCounter(apiRequestsTotal.MustCurryWith(labels), transport)
+
+	return &http.Client{Transport: transport}
+}
+
+// observeScrape records a scrape's duration and, if err is non-nil, counts
+// it against scrapeErrorsTotal for the given domain/stage.
+func observeScrape(domain, stage string, start time.Time, err error) {
+	scrapeDuration.WithLabelValues(domain).Observe(time.Since(start).Seconds())
+	if err != nil {
+		scrapeErrorsTotal.WithLabelValues(domain, stage).Inc()
+	}
+}
+
+// metricsHandler mirrors promhttp.Handler(), but also registers the
+// "promhttp_metric_handler_errors_total" counter against the default
+// registry, so failures inside the handler itself - not just in Mailgun
+// collection - are observable.
+func metricsHandler() http.Handler {
+	opts := promhttp.HandlerOpts{Registry: prometheus.DefaultRegisterer, ErrorHandling: promhttp.ContinueOnError}
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(prometheus.DefaultGatherer, opts))
+}
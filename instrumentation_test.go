@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestInstrumentedMailgunClientRecordsRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	before := apiRequestsTotalValue(t, "stats", "200")
+
+	client := instrumentedMailgunClient("stats")
+	if _, err := client.Get(server.URL); err != nil {
+		t.Fatalf("unexpected error making request: %v", err)
+	}
+
+	if got := apiRequestsTotalValue(t, "stats", "200"); got != before+1 {
+		t.Errorf("expected apiRequestsTotal{endpoint=stats,code=200} to increase by 1, got %v -> %v", before, got)
+	}
+}
+
+func TestObserveScrapeCountsErrorsByStage(t *testing.T) {
+	before := scrapeErrorsTotalValue(t, "example.com", "stats")
+
+	observeScrape("example.com", "stats", time.Now(), nil)
+	if got := scrapeErrorsTotalValue(t, "example.com", "stats"); got != before {
+		t.Errorf("expected a successful scrape not to count as an error, got %v -> %v", before, got)
+	}
+
+	observeScrape("example.com", "stats", time.Now(), errStub{})
+	if got := scrapeErrorsTotalValue(t, "example.com", "stats"); got != before+1 {
+		t.Errorf("expected a failed scrape to count as an error, got %v -> %v", before, got)
+	}
+}
+
+type errStub struct{}
+
+func (errStub) Error() string { return "stub error" }
+
+func apiRequestsTotalValue(t *testing.T, endpoint, code string) float64 {
+	t.Helper()
+
+	metric, err := apiRequestsTotal.GetMetricWithLabelValues(endpoint, code)
+	if err != nil {
+		t.Fatalf("GetMetricWithLabelValues: %v", err)
+	}
+
+	var out dto.Metric
+	if err := metric.Write(&out); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	return out.GetCounter().GetValue()
+}
+
+func scrapeErrorsTotalValue(t *testing.T, domain, stage string) float64 {
+	t.Helper()
+
+	metric, err := scrapeErrorsTotal.GetMetricWithLabelValues(domain, stage)
+	if err != nil {
+		t.Fatalf("GetMetricWithLabelValues: %v", err)
+	}
+
+	var out dto.Metric
+	if err := metric.Write(&out); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	return out.GetCounter().GetValue()
+}
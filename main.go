@@ -3,17 +3,25 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
+	gokitlog "github.com/go-kit/log"
 	"github.com/mailgun/mailgun-go/v4"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/common/version"
+	"github.com/prometheus/exporter-toolkit/web"
+	webflag "github.com/prometheus/exporter-toolkit/web/kingpinflag"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -24,66 +32,93 @@ const (
 
 // Exporter collects metrics from Mailgun's via their API.
 type Exporter struct {
-	domains              []string
-	APIKey               string
-	APIBase              string
-	scrapeStart          time.Time
-	up                   *prometheus.Desc
-	acceptedTotal        *prometheus.Desc
-	clickedTotal         *prometheus.Desc
-	complainedTotal      *prometheus.Desc
-	deliveredTotal       *prometheus.Desc
-	failedPermanentTotal *prometheus.Desc
-	failedTemporaryTotal *prometheus.Desc
-	openedTotal          *prometheus.Desc
-	storedTotal          *prometheus.Desc
-	unsubscribedTotal    *prometheus.Desc
-	state                *prometheus.Desc
+	domains       []string
+	APIKey        string
+	APIBase       string
+	resolution    mailgun.Resolution
+	window        time.Duration
+	concurrency   int
+	domainTimeout time.Duration
+
+	// scrapeMu ensures at most one scrape of this Exporter runs at a time.
+	// withScrapeContext holds it for the duration of a whole request, so a
+	// second concurrent /metrics request waits rather than overwriting
+	// scrapeCtx out from under the scrape already in progress.
+	scrapeMu  sync.Mutex
+	scrapeCtx context.Context
+
+	up              *prometheus.Desc
+	accepted        *windowedMetric
+	clicked         *windowedMetric
+	complained      *windowedMetric
+	delivered       *windowedMetric
+	failedPermanent *windowedMetric
+	failedTemporary *windowedMetric
+	opened          *windowedMetric
+	stored          *windowedMetric
+	unsubscribed    *windowedMetric
+	state           *prometheus.Desc
 }
 
-func prometheusDomainStatsDesc(metric string, help string) *prometheus.Desc {
-	return prometheus.NewDesc(
-		prometheus.BuildFQName(
-			namespace,
-			"domain_stats",
-			fmt.Sprintf("%s_total", metric),
-		),
-		help,
-		[]string{"name"},
-		nil,
-	)
+// parseResolution maps a --stats.resolution flag value onto the Resolution
+// enum GetStats expects.
+func parseResolution(s string) (mailgun.Resolution, error) {
+	switch mailgun.Resolution(s) {
+	case mailgun.ResolutionHour, mailgun.ResolutionDay, mailgun.ResolutionMonth:
+		return mailgun.Resolution(s), nil
+	default:
+		return "", fmt.Errorf("invalid stats resolution %q, must be one of hour, day, month", s)
+	}
 }
 
-func prometheusDomainStatsTypeDesc(metric string, help string) *prometheus.Desc {
-	return prometheus.NewDesc(
-		prometheus.BuildFQName(
-			namespace,
-			"domain_stats",
-			fmt.Sprintf("%s_total", metric),
-		),
-		help,
-		[]string{"name", "type"},
-		nil,
-	)
+// windowLabel renders the configured stats window as a short label value,
+// e.g. "168h" becomes "7d".
+func windowLabel(window time.Duration) string {
+	if window%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", window/(24*time.Hour))
+	}
+
+	return window.String()
 }
 
-// NewExporter returns an initialized exporter.
-func NewExporter() *Exporter {
+// mailgunConfigFromEnv reads the domains to scrape and the API credentials
+// shared by every collector from the environment, terminating the process
+// if a required variable is missing.
+func mailgunConfigFromEnv() (domains []string, apiKey, apiBase string) {
 	scrapeDomains := os.Getenv("SCRAPE_DOMAINS")
 	if scrapeDomains == "" {
 		log.Fatal().Msg("required environment variable SCRAPE_DOMAINS not defined")
 	}
 
-	apiKey := os.Getenv("MG_API_KEY")
+	apiKey = os.Getenv("MG_API_KEY")
 	if apiKey == "" {
 		log.Fatal().Msg("required environment variable MG_API_KEY not defined")
 	}
 
+	return strings.Split(scrapeDomains, ","), apiKey, os.Getenv("API_BASE")
+}
+
+// NewExporter returns an initialized exporter. resolution and window
+// control the sliding window every scrape queries GetStats over, e.g.
+// "last 7 days at day resolution". concurrency bounds how many domains are
+// scraped in parallel (values below 1 are treated as 1); domainTimeout
+// bounds how long a single domain's GetStats call is allowed to take.
+func NewExporter(
+	domains []string, apiKey, apiBase string, resolution mailgun.Resolution, window time.Duration,
+	concurrency int, domainTimeout time.Duration,
+) *Exporter {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
 	return &Exporter{
-		domains:     strings.Split(scrapeDomains, ","),
-		APIKey:      apiKey,
-		APIBase:     os.Getenv("API_BASE"),
-		scrapeStart: time.Now().UTC(),
+		domains:       domains,
+		APIKey:        apiKey,
+		APIBase:       apiBase,
+		resolution:    resolution,
+		window:        window,
+		concurrency:   concurrency,
+		domainTimeout: domainTimeout,
 		up: prometheus.NewDesc(
 			prometheus.BuildFQName(
 				"mailgun",
@@ -94,39 +129,43 @@ func NewExporter() *Exporter {
 			nil,
 			nil,
 		),
-		acceptedTotal: prometheusDomainStatsTypeDesc(
+		accepted: newWindowedMetric(
 			"accepted",
 			"Mailgun accepted the request for incoming/outgoing to send/forward the email and the message has been placed in queue.",
+			"type",
 		),
-		clickedTotal: prometheusDomainStatsDesc(
+		clicked: newWindowedMetric(
 			"clicked",
 			"The email recipient clicked on a link in the email.",
 		),
-		complainedTotal: prometheusDomainStatsDesc(
+		complained: newWindowedMetric(
 			"complained",
 			"The email recipient clicked on the spam complaint button within their email client.",
 		),
-		deliveredTotal: prometheusDomainStatsTypeDesc(
+		delivered: newWindowedMetric(
 			"delivered",
 			"Mailgun sent the email via HTTP or SMTP and it was accepted by the recipient email server.",
+			"type",
 		),
-		failedPermanentTotal: prometheusDomainStatsTypeDesc(
+		failedPermanent: newWindowedMetric(
 			"failed_permanent",
 			"All permanently failed emails. Includes bounce, delayed bounce, suppress bounce, suppress complaint, suppress unsubscribe",
+			"type",
 		),
-		failedTemporaryTotal: prometheusDomainStatsTypeDesc(
+		failedTemporary: newWindowedMetric(
 			"failed_temporary",
 			"All temporary failed emails due to ESP block, that will be retried",
+			"type",
 		),
-		openedTotal: prometheusDomainStatsDesc(
+		opened: newWindowedMetric(
 			"opened",
 			"The email recipient opened the email and enabled image viewing.",
 		),
-		storedTotal: prometheusDomainStatsDesc(
+		stored: newWindowedMetric(
 			"stored",
 			"The email recipient opened the email and enabled image viewing.",
 		),
-		unsubscribedTotal: prometheusDomainStatsDesc(
+		unsubscribed: newWindowedMetric(
 			"unsubscribed",
 			"The email recipient clicked on the unsubscribe link.",
 		),
@@ -143,152 +182,176 @@ func NewExporter() *Exporter {
 	}
 }
 
+// withScrapeContext arranges for ctx to be used as the parent of every
+// GetStats call made by the next Collect, so an HTTP handler can bound a
+// scrape by the lifetime of the request that triggered it. It holds
+// scrapeMu until the returned func is called, so a second request arriving
+// while a scrape is still running waits its turn rather than racing the
+// first request's context.
+func (e *Exporter) withScrapeContext(ctx context.Context) func() {
+	e.scrapeMu.Lock()
+	e.scrapeCtx = ctx
+
+	return func() {
+		e.scrapeCtx = nil
+		e.scrapeMu.Unlock()
+	}
+}
+
+// context returns the context set by withScrapeContext. It deliberately
+// doesn't take scrapeMu: it's only ever called from goroutines spawned by
+// the Collect that runs while the request holding scrapeMu is still in
+// flight, so the write in withScrapeContext already happens-before any
+// read here.
+func (e *Exporter) context() context.Context {
+
+	if e.scrapeCtx != nil {
+		return e.scrapeCtx
+	}
+
+	return context.Background()
+}
+
 // Describe implements prometheus.Collector.
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.up
-	ch <- e.acceptedTotal
-	ch <- e.clickedTotal
-	ch <- e.complainedTotal
-	ch <- e.deliveredTotal
-	ch <- e.failedPermanentTotal
-	ch <- e.failedTemporaryTotal
-	ch <- e.openedTotal
-	ch <- e.storedTotal
-	ch <- e.unsubscribedTotal
+	e.accepted.Describe(ch)
+	e.clicked.Describe(ch)
+	e.complained.Describe(ch)
+	e.delivered.Describe(ch)
+	e.failedPermanent.Describe(ch)
+	e.failedTemporary.Describe(ch)
+	e.opened.Describe(ch)
+	e.stored.Describe(ch)
+	e.unsubscribed.Describe(ch)
 	ch <- e.state
 }
 
-// Collect implements prometheus.Collector. It only initiates a scrape of
-// Collins if no scrape is currently ongoing. If a scrape of Collins is
-// currently ongoing, Collect waits for it to end and then uses its result to
-// collect the metrics.
+// domainStats is the result of scraping a single domain's GetStats.
+type domainStats struct {
+	domain string
+	stats  []mailgun.Stats
+	err    error
+}
+
+// Collect implements prometheus.Collector. It fans the per-domain GetStats
+// calls out across a worker pool bounded by e.concurrency, so scraping N
+// domains costs roughly domainTimeout·ceil(N/concurrency) instead of
+// domainTimeout·N.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	var scrapeOK float64 = 1
+	window := windowLabel(e.window)
+
+	results := make(chan domainStats, len(e.domains))
+	sem := make(chan struct{}, e.concurrency)
 
+	var wg sync.WaitGroup
 	for _, domain := range e.domains {
-		stats, err := e.getStats(domain)
-		if err != nil {
-			ch <- prometheus.MustNewConstMetric(e.state, prometheus.GaugeValue, 0, domain)
-			log.Error().Err(err)
-			scrapeOK = 0
+		wg.Add(1)
+
+		go func(domain string) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			scrapeInFlight.Inc()
+			defer scrapeInFlight.Dec()
+
+			start := time.Now()
+			stats, err := e.getStats(domain)
+			observeScrape(domain, "stats", start, err)
+
+			results <- domainStats{domain: domain, stats: stats, err: err}
+		}(domain)
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var scrapeOK int32 = 1
+
+	for result := range results {
+		if result.err != nil {
+			ch <- prometheus.MustNewConstMetric(e.state, prometheus.GaugeValue, 0, result.domain)
+			log.Error().Err(result.err)
+			atomic.StoreInt32(&scrapeOK, 0)
 
 			continue
 		}
 
+		domain, stats := result.domain, result.stats
+
 		ch <- prometheus.MustNewConstMetric(e.state, prometheus.GaugeValue, 1, domain)
 
-		acceptedTotalIncoming := float64(0)
-		acceptedTotalOutgoing := float64(0)
-		clickedTotal := float64(0)
-		complainedTotal := float64(0)
-		deliveredHTTPTotal := float64(0)
-		deliveredSMTPTotal := float64(0)
+		acceptedIncoming := float64(0)
+		acceptedOutgoing := float64(0)
+		clicked := float64(0)
+		complained := float64(0)
+		deliveredHTTP := float64(0)
+		deliveredSMTP := float64(0)
 		failedPermanentBounce := float64(0)
 		failedPermanentDelayedBounce := float64(0)
 		failedPermanentSuppressBounce := float64(0)
 		failedPermanentSuppressComplaint := float64(0)
 		failedPermanentSuppressUnsubscribe := float64(0)
 		failedTemporaryEspblock := float64(0)
-		openedTotal := float64(0)
-		storedTotal := float64(0)
-		unsubscribedTotal := float64(0)
+		opened := float64(0)
+		stored := float64(0)
+		unsubscribed := float64(0)
 
 		for _, stat := range stats {
-			acceptedTotalIncoming += float64(stat.Accepted.Incoming)
-			acceptedTotalOutgoing += float64(stat.Accepted.Outgoing)
-			clickedTotal += float64(stat.Clicked.Total)
-			complainedTotal += float64(stat.Complained.Total)
-			complainedTotal += float64(stat.Complained.Total)
-			deliveredHTTPTotal += float64(stat.Delivered.Http)
-			deliveredSMTPTotal += float64(stat.Delivered.Smtp)
+			acceptedIncoming += float64(stat.Accepted.Incoming)
+			acceptedOutgoing += float64(stat.Accepted.Outgoing)
+			clicked += float64(stat.Clicked.Total)
+			complained += float64(stat.Complained.Total)
+			deliveredHTTP += float64(stat.Delivered.Http)
+			deliveredSMTP += float64(stat.Delivered.Smtp)
 			failedPermanentBounce += float64(stat.Failed.Permanent.Bounce)
 			failedPermanentDelayedBounce += float64(stat.Failed.Permanent.DelayedBounce)
 			failedPermanentSuppressBounce += float64(stat.Failed.Permanent.SuppressBounce)
 			failedPermanentSuppressComplaint += float64(stat.Failed.Permanent.SuppressComplaint)
 			failedPermanentSuppressUnsubscribe += float64(stat.Failed.Permanent.SuppressUnsubscribe)
 			failedTemporaryEspblock += float64(stat.Failed.Temporary.Espblock)
-			openedTotal += float64(stat.Opened.Total)
-			storedTotal += float64(stat.Stored.Total)
-			unsubscribedTotal += float64(stat.Unsubscribed.Total)
+			opened += float64(stat.Opened.Total)
+			stored += float64(stat.Stored.Total)
+			unsubscribed += float64(stat.Unsubscribed.Total)
 		}
 
-		// Begin Accepted Total
-		ch <- prometheus.MustNewConstMetric(
-			e.acceptedTotal,
-			prometheus.CounterValue,
-			acceptedTotalIncoming,
-			domain, "incoming",
-		)
-		ch <- prometheus.MustNewConstMetric(
-			e.acceptedTotal,
-			prometheus.CounterValue,
-			acceptedTotalOutgoing,
-			domain, "outgoing",
-		)
-		// End Accepted Total
-
-		ch <- prometheus.MustNewConstMetric(e.clickedTotal, prometheus.CounterValue, clickedTotal, domain)
-		ch <- prometheus.MustNewConstMetric(e.complainedTotal, prometheus.CounterValue, complainedTotal, domain)
-
-		// Begin Delivered Total
-		ch <- prometheus.MustNewConstMetric(
-			e.deliveredTotal,
-			prometheus.CounterValue,
-			deliveredHTTPTotal,
-			domain, "http",
-		)
-		ch <- prometheus.MustNewConstMetric(
-			e.deliveredTotal,
-			prometheus.CounterValue,
-			deliveredSMTPTotal,
-			domain, "smtp",
-		)
-		// End Delivered Total
-
-		// Begin Failed Permanent Total
-		ch <- prometheus.MustNewConstMetric(
-			e.failedPermanentTotal,
-			prometheus.CounterValue,
-			failedPermanentBounce,
-			domain, "bounce",
-		)
-		ch <- prometheus.MustNewConstMetric(
-			e.failedPermanentTotal,
-			prometheus.CounterValue,
-			failedPermanentDelayedBounce,
-			domain, "delayed_bounce",
-		)
-		ch <- prometheus.MustNewConstMetric(
-			e.failedPermanentTotal,
-			prometheus.CounterValue,
-			failedPermanentSuppressBounce,
-			domain, "suppress_bounce",
-		)
-		ch <- prometheus.MustNewConstMetric(
-			e.failedPermanentTotal,
-			prometheus.CounterValue,
-			failedPermanentSuppressComplaint,
-			domain, "suppress_complaint",
-		)
-		ch <- prometheus.MustNewConstMetric(e.failedPermanentTotal, prometheus.CounterValue,
-			failedPermanentSuppressUnsubscribe,
-			domain, "suppress_unsubscribe",
-		)
-		// End Failed Permanent Total
-
-		ch <- prometheus.MustNewConstMetric(
-			e.failedTemporaryTotal,
-			prometheus.CounterValue,
-			failedTemporaryEspblock,
-			domain, "esp_block",
-		)
-
-		ch <- prometheus.MustNewConstMetric(e.openedTotal, prometheus.CounterValue, openedTotal, domain)
-		ch <- prometheus.MustNewConstMetric(e.storedTotal, prometheus.CounterValue, storedTotal, domain)
-		ch <- prometheus.MustNewConstMetric(e.unsubscribedTotal, prometheus.CounterValue, unsubscribedTotal, domain)
+		e.accepted.observe(ch, window, acceptedIncoming, domain, "incoming")
+		e.accepted.observe(ch, window, acceptedOutgoing, domain, "outgoing")
+
+		e.clicked.observe(ch, window, clicked, domain)
+		e.complained.observe(ch, window, complained, domain)
+
+		e.delivered.observe(ch, window, deliveredHTTP, domain, "http")
+		e.delivered.observe(ch, window, deliveredSMTP, domain, "smtp")
+
+		e.failedPermanent.observe(ch, window, failedPermanentBounce, domain, "bounce")
+		e.failedPermanent.observe(ch, window, failedPermanentDelayedBounce, domain, "delayed_bounce")
+		e.failedPermanent.observe(ch, window, failedPermanentSuppressBounce, domain, "suppress_bounce")
+		e.failedPermanent.observe(ch, window, failedPermanentSuppressComplaint, domain, "suppress_complaint")
+		e.failedPermanent.observe(ch, window, failedPermanentSuppressUnsubscribe, domain, "suppress_unsubscribe")
+
+		e.failedTemporary.observe(ch, window, failedTemporaryEspblock, domain, "esp_block")
+
+		e.opened.observe(ch, window, opened, domain)
+		e.stored.observe(ch, window, stored, domain)
+		e.unsubscribed.observe(ch, window, unsubscribed, domain)
 	}
 
-	ch <- prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, scrapeOK)
+	e.accepted.Collect(ch)
+	e.clicked.Collect(ch)
+	e.complained.Collect(ch)
+	e.delivered.Collect(ch)
+	e.failedPermanent.Collect(ch)
+	e.failedTemporary.Collect(ch)
+	e.opened.Collect(ch)
+	e.stored.Collect(ch)
+	e.unsubscribed.Collect(ch)
+
+	ch <- prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, float64(atomic.LoadInt32(&scrapeOK)))
 }
 
 func (e *Exporter) getStats(domain string) ([]mailgun.Stats, error) {
@@ -296,26 +359,189 @@ func (e *Exporter) getStats(domain string) ([]mailgun.Stats, error) {
 	if e.APIBase != "" {
 		mg.SetAPIBase(e.APIBase)
 	}
+	mg.SetClient(instrumentedMailgunClient("stats"))
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	ctx, cancel := context.WithTimeout(e.context(), e.domainTimeout)
 	defer cancel()
 
+	now := time.Now().UTC()
+
 	return mg.GetStats(ctx, []string{
 		"accepted", "clicked", "complained", "delivered", "failed", "opened", "stored", "unsubscribed",
 	}, &mailgun.GetStatOptions{
-		Resolution: mailgun.ResolutionHour,
-		Start:      e.scrapeStart,
+		Resolution: e.resolution,
+		Start:      now.Add(-e.window),
+		End:        now,
+	})
+}
+
+func landingPageHTML(metricsPath string) []byte {
+	return []byte(`<html>
+			<head><title>Mailgun Exporter</title></head>
+            <body>
+            <h1>Mailgun Exporter</h1>
+            <p><a href='` + metricsPath + `'>Metrics</a></p>
+			<p><a href='/healthz'>Health</a></p>
+			<p><a href='/probe?target='>Probe</a> a single Mailgun domain, e.g. /probe?target=example.com</p>
+            </body>
+            </html>`)
+}
+
+// newMainMux builds the mux for the public listener: the landing page,
+// health check and /probe, plus /metrics when the metrics endpoint hasn't
+// been split onto its own listener.
+func newMainMux(metricsPath string, includeMetrics bool, exp *Exporter, probeHandler http.HandlerFunc) *http.ServeMux {
+	mux := http.NewServeMux()
+	if includeMetrics {
+		mux.Handle(metricsPath, withScrapeDeadline(exp, metricsHandler()))
+	}
+	mux.HandleFunc("/probe", probeHandler)
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(landingPageHTML(metricsPath))
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
 	})
+	return mux
+}
+
+// newMetricsMux builds the mux for the dedicated metrics listener: just
+// /metrics, nothing else.
+func newMetricsMux(metricsPath string, exp *Exporter) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, withScrapeDeadline(exp, metricsHandler()))
+	return mux
+}
+
+// withScrapeDeadline makes the request's context available to exp's Collect
+// for the duration of the request, so a client's own timeout (or the
+// overall Prometheus scrape_timeout) bounds how long the underlying
+// per-domain GetStats calls are allowed to run.
+func withScrapeDeadline(exp *Exporter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		done := exp.withScrapeContext(r.Context())
+		defer done()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// toolkitLogger adapts our zerolog logger to the github.com/go-kit/log
+// interface expected by exporter-toolkit's web.ListenAndServe.
+type toolkitLogger struct {
+	logger zerolog.Logger
+}
+
+func (l toolkitLogger) Log(keyvals ...interface{}) error {
+	e := l.logger.Info()
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		e = e.Interface(fmt.Sprintf("%v", keyvals[i]), keyvals[i+1])
+	}
+	e.Send()
+
+	return nil
+}
+
+// namedServer pairs an HTTP server with the web-config flags it should be
+// served with, so it can be started and shut down alongside its siblings.
+type namedServer struct {
+	name   string
+	server *http.Server
+	flags  *web.FlagConfig
+}
+
+// runServers starts every server concurrently, honouring their individual
+// web-config (TLS/basic-auth), and shuts them all down gracefully once ctx
+// is cancelled. It returns the first error encountered, ignoring the
+// expected http.ErrServerClosed on shutdown.
+func runServers(ctx context.Context, servers []namedServer, logger gokitlog.Logger) error {
+	var (
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		runErr  error
+	)
+
+	for _, s := range servers {
+		wg.Add(1)
+
+		go func(s namedServer) {
+			defer wg.Done()
+
+			log.Info().Msgf("Starting %s HTTP server on %v", s.name, *s.flags.WebListenAddresses)
+
+			if err := web.ListenAndServe(s.server, s.flags, logger); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errOnce.Do(func() { runErr = fmt.Errorf("%s server: %w", s.name, err) })
+			}
+		}(s)
+	}
+
+	<-ctx.Done()
+
+	for _, s := range servers {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := s.server.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Msgf("error shutting down %s HTTP server", s.name)
+		}
+		cancel()
+	}
+
+	wg.Wait()
+
+	return runErr
 }
 
 func main() {
 	var (
-		listenAddress = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").
-				Default(":9616").
-				String()
+		toolkitFlags         = webflag.AddFlags(kingpin.CommandLine, ":9616")
+		metricsListenAddress = kingpin.Flag(
+			"web.listen-metrics-address",
+			"Address to expose /metrics on, separate from --web.listen-address. "+
+				"When unset, metrics continue to be served on --web.listen-address.",
+		).Envar("WEB_LISTEN_METRICS_ADDRESS").String()
 		metricsPath = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").
 				Default("/metrics").
 				String()
+		eventsEnabled = kingpin.Flag(
+			"events.enabled",
+			"Enable the Events API based per-message collector (bounce reasons, delivery latency, tag/recipient-domain breakdowns).",
+		).Envar("EVENTS_ENABLED").Bool()
+		eventsMaxAge = kingpin.Flag(
+			"events.max-age",
+			"How far back the events collector will backfill on first scrape or after a restart with no state file.",
+		).Envar("EVENTS_MAX_AGE").Default("24h").Duration()
+		eventsLabelTag = kingpin.Flag(
+			"events.label.tag",
+			"Include the message's first tag as a label on events metrics. Leave off if tags have high cardinality.",
+		).Envar("EVENTS_LABEL_TAG").Bool()
+		eventsLabelRecipientDomain = kingpin.Flag(
+			"events.label.recipient-domain",
+			"Include the recipient's domain as a label on events metrics.",
+		).Envar("EVENTS_LABEL_RECIPIENT_DOMAIN").Bool()
+		stateFile = kingpin.Flag(
+			"state-file",
+			"Path to persist the events collector's per-domain last-seen timestamp across restarts. When unset, state is kept in memory only.",
+		).Envar("STATE_FILE").String()
+		probeConfigFile = kingpin.Flag(
+			"probe.config-file",
+			"Path to a YAML file mapping Mailgun domains to api_key/api_base overrides for /probe. "+
+				"Domains not listed fall back to MG_API_KEY/API_BASE.",
+		).Envar("PROBE_CONFIG_FILE").String()
+		statsResolution = kingpin.Flag(
+			"stats.resolution",
+			"Resolution Mailgun buckets domain stats at: hour, day or month.",
+		).Envar("STATS_RESOLUTION").Default("day").String()
+		statsWindow = kingpin.Flag(
+			"stats.window",
+			"How far back domain stats are queried on every scrape.",
+		).Envar("STATS_WINDOW").Default("168h").Duration()
+		scrapeConcurrency = kingpin.Flag(
+			"scrape.concurrency",
+			"Maximum number of domains scraped in parallel on a single /metrics request.",
+		).Envar("SCRAPE_CONCURRENCY").Default("4").Int()
+		scrapeTimeout = kingpin.Flag(
+			"scrape.timeout",
+			"Maximum time a single domain's GetStats call is allowed to take.",
+		).Envar("SCRAPE_TIMEOUT").Default("30s").Duration()
 	)
 
 	if fileInfo, _ := os.Stdout.Stat(); (fileInfo.Mode() & os.ModeCharDevice) != 0 {
@@ -328,25 +554,65 @@ func main() {
 	log.Info().Msgf("Starting Mailgun exporter %v", version.Info())
 	log.Info().Msgf("Build context %v", version.BuildContext())
 
-	prometheus.MustRegister(NewExporter())
-	http.Handle(*metricsPath, promhttp.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
-		w.Write([]byte(`<html>
-			<head><title>Mailgun Exporter</title></head>
-            <body>
-            <h1>Mailgun Exporter</h1>
-            <p><a href='` + *metricsPath + `'>Metrics</a></p>
-			<p><a href='/healthz'>Health</a></p>
-            </body>
-            </html>`))
-	})
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
-		w.WriteHeader(http.StatusNoContent)
-	})
-	log.Info().
-		Msgf("Starting HTTP server on listen address %s and metric path %s", *listenAddress, *metricsPath)
+	domains, apiKey, apiBase := mailgunConfigFromEnv()
+
+	resolution, err := parseResolution(*statsResolution)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid --stats.resolution")
+	}
+
+	registerInstrumentation(prometheus.DefaultRegisterer)
+	prometheus.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	exporter := NewExporter(domains, apiKey, apiBase, resolution, *statsWindow, *scrapeConcurrency, *scrapeTimeout)
+	prometheus.MustRegister(exporter)
+
+	if *eventsEnabled {
+		prometheus.MustRegister(NewEventsCollector(
+			domains, apiKey, apiBase, *eventsMaxAge, *stateFile, *eventsLabelTag, *eventsLabelRecipientDomain,
+		))
+	} else {
+		log.Info().Msg("events.enabled not set, skipping the per-message events collector")
+	}
+
+	probeCfg, err := loadProbeConfig(*probeConfigFile)
+	if err != nil {
+		log.Fatal().Err(err).Str("file", *probeConfigFile).Msg("failed to load probe config file")
+	}
+
+	splitMetrics := *metricsListenAddress != ""
+
+	probeHandler := newProbeHandler(
+		apiKey, apiBase, probeCfg, resolution, *statsWindow, *scrapeConcurrency, *scrapeTimeout,
+	)
+
+	servers := []namedServer{
+		{
+			name: "web",
+			server: &http.Server{
+				Handler: newMainMux(*metricsPath, !splitMetrics, exporter, probeHandler),
+			},
+			flags: toolkitFlags,
+		},
+	}
+
+	if splitMetrics {
+		servers = append(servers, namedServer{
+			name:   "metrics",
+			server: &http.Server{Handler: newMetricsMux(*metricsPath, exporter)},
+			flags: &web.FlagConfig{
+				WebListenAddresses: &[]string{*metricsListenAddress},
+				WebConfigFile:      toolkitFlags.WebConfigFile,
+			},
+		})
+	} else {
+		log.Info().Msg("web.listen-metrics-address not set, skipping the dedicated metrics listener")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	if err := http.ListenAndServe(*listenAddress, nil); err != nil {
-		log.Fatal().Err(err).Msgf("%v", err)
+	if err := runServers(ctx, servers, toolkitLogger{logger: log.Logger}); err != nil {
+		log.Fatal().Err(err).Msg("error running HTTP server")
 	}
 }
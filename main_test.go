@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+func noopProbeHandler(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func testExporter() *Exporter {
+	return NewExporter(nil, "key", "", mailgun.ResolutionDay, 7*24*time.Hour, 4, 30*time.Second)
+}
+
+func TestNewMainMuxIncludesMetricsByDefault(t *testing.T) {
+	mux := newMainMux("/metrics", true, testExporter(), noopProbeHandler)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected /metrics to be served on the main mux, got status %d", rec.Code)
+	}
+}
+
+func TestNewMainMuxExcludesMetricsWhenSplit(t *testing.T) {
+	mux := newMainMux("/metrics", false, testExporter(), noopProbeHandler)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if got := rec.Body.String(); !strings.Contains(got, "Mailgun Exporter") {
+		t.Errorf("expected unregistered /metrics to fall through to the landing page, got body %q", got)
+	}
+}
+
+func TestNewMainMuxServesLandingPageAndHealthz(t *testing.T) {
+	mux := newMainMux("/metrics", true, testExporter(), noopProbeHandler)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected / to return 200, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("expected /healthz to return 204, got %d", rec.Code)
+	}
+}
+
+func TestNewMetricsMuxOnlyServesMetrics(t *testing.T) {
+	mux := newMetricsMux("/metrics", testExporter())
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected /metrics to be served on the metrics-only mux, got status %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code == http.StatusOK {
+		t.Error("expected the metrics-only mux to not serve the landing page")
+	}
+}
+
+func TestExporterContextDefaultsToBackground(t *testing.T) {
+	exp := testExporter()
+
+	if exp.context() != context.Background() {
+		t.Error("expected context() to return context.Background() with no scrape in progress")
+	}
+}
+
+func TestWithScrapeContextIsScopedToOneScrape(t *testing.T) {
+	exp := testExporter()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := exp.withScrapeContext(ctx)
+	if exp.context() != ctx {
+		t.Error("expected context() to return the context set by withScrapeContext")
+	}
+
+	done()
+	if exp.context() != context.Background() {
+		t.Error("expected context() to fall back to context.Background() once the scrape is done")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mailgun/mailgun-go/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gopkg.in/yaml.v2"
+)
+
+// probeTarget overrides the credentials used for a single Mailgun domain
+// probed via /probe, e.g. for a different Mailgun account than the one
+// configured through MG_API_KEY/API_BASE.
+type probeTarget struct {
+	APIKey  string `yaml:"api_key"`
+	APIBase string `yaml:"api_base"`
+}
+
+// probeConfig maps Mailgun domains to the credentials /probe should use for
+// them, in the style of blackbox_exporter's module config.
+type probeConfig struct {
+	Targets map[string]probeTarget `yaml:"targets"`
+}
+
+// loadProbeConfig reads and parses the YAML file at path. An empty path is
+// not an error; it simply means no per-target overrides are configured.
+func loadProbeConfig(path string) (*probeConfig, error) {
+	if path == "" {
+		return &probeConfig{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg probeConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// newProbeHandler returns the /probe handler. For each request it builds a
+// fresh Exporter for the requested target, registered on a private
+// prometheus.Registry, so concurrent probes of different domains never
+// share state and a slow/failing target can't poison another's scrape.
+// Credentials are resolved in order: the request's own api_key/api_base
+// query parameters, then the target's entry in the probe config file, then
+// the exporter's own default credentials.
+func newProbeHandler(
+	defaultAPIKey, defaultAPIBase string, cfg *probeConfig, resolution mailgun.Resolution, window time.Duration,
+	concurrency int, domainTimeout time.Duration,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		apiKey := r.URL.Query().Get("api_key")
+		apiBase := r.URL.Query().Get("api_base")
+
+		if t, ok := cfg.Targets[target]; ok {
+			if apiKey == "" {
+				apiKey = t.APIKey
+			}
+			if apiBase == "" {
+				apiBase = t.APIBase
+			}
+		}
+
+		if apiKey == "" {
+			apiKey = defaultAPIKey
+		}
+		if apiBase == "" {
+			apiBase = defaultAPIBase
+		}
+
+		if apiKey == "" {
+			http.Error(w, "no api_key configured for target "+target, http.StatusBadRequest)
+			return
+		}
+
+		exp := NewExporter([]string{target}, apiKey, apiBase, resolution, window, concurrency, domainTimeout)
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(exp)
+
+		done := exp.withScrapeContext(r.Context())
+		defer done()
+
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}
+}
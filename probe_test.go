@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+func TestProbeHandlerRequiresTarget(t *testing.T) {
+	handler := newProbeHandler("default-key", "", &probeConfig{}, mailgun.ResolutionDay, 7*24*time.Hour, 4, 30*time.Second)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/probe", nil))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a missing target to return 400, got %d", rec.Code)
+	}
+}
+
+func TestProbeHandlerRequiresAPIKey(t *testing.T) {
+	handler := newProbeHandler("", "", &probeConfig{}, mailgun.ResolutionDay, 7*24*time.Hour, 4, 30*time.Second)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/probe?target=example.com", nil))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a target with no resolvable api_key to return 400, got %d", rec.Code)
+	}
+}
+
+func TestLoadProbeConfigEmptyPathIsNotAnError(t *testing.T) {
+	cfg, err := loadProbeConfig("")
+	if err != nil {
+		t.Fatalf("expected no error for an unset config file, got %v", err)
+	}
+	if cfg == nil || len(cfg.Targets) != 0 {
+		t.Errorf("expected an empty config, got %+v", cfg)
+	}
+}
+
+func TestLoadProbeConfigMissingFileIsAnError(t *testing.T) {
+	if _, err := loadProbeConfig("/nonexistent/probe-config.yaml"); err == nil {
+		t.Error("expected an error for a config file that doesn't exist")
+	}
+}